@@ -10,6 +10,7 @@ import (
 	"io"
 	"math"
 
+	"github.com/klauspost/compress/flate"
 	"golang.org/x/text/encoding"
 )
 
@@ -22,6 +23,14 @@ var NativeByteOrder = binary.LittleEndian
 type transferSyntaxStackEntry struct {
 	bo       binary.ByteOrder
 	implicit IsImplicitVR
+
+	// Saved Encoder stream state, restored by Encoder.PopTransferSyntax.
+	out      io.Writer
+	flateOut *flate.Writer
+
+	// Saved Decoder stream state, restored by Decoder.PopTransferSyntax.
+	in      *bufio.Reader
+	flateIn io.ReadCloser
 }
 
 type stackEntry struct {
@@ -33,12 +42,23 @@ type stackEntry struct {
 type Encoder struct {
 	err error
 
-	out io.Writer
-	bo  binary.ByteOrder
+	// out is the writer currently being written to. It is either rawOut, or
+	// flateOut wrapping rawOut when the current transfer syntax is
+	// Deflated Explicit VR Little Endian.
+	out    io.Writer
+	rawOut io.Writer
+	// flateOut is non-nil iff out == flateOut.
+	flateOut *flate.Writer
+
+	bo binary.ByteOrder
 	// "implicit" isn't used by Encoder internally. It's there for the user
 	// of Encoder to see the current transfer syntax.
 	implicit IsImplicitVR
 
+	// scratch is reused by WriteByte/WriteUInt16/... to avoid allocating on
+	// every primitive write.
+	scratch [8]byte
+
 	// Stack of old transfer syntaxes. Used by {Push,Pop}TransferSyntax.
 	oldTransferSyntaxes []transferSyntaxStackEntry
 }
@@ -46,9 +66,11 @@ type Encoder struct {
 // NewBytesEncoder creates a new Encoder that writes to an in-memory buffer. The
 // contents can be obtained via Bytes() method.
 func NewBytesEncoder(bo binary.ByteOrder, implicit IsImplicitVR) *Encoder {
+	buf := &bytes.Buffer{}
 	return &Encoder{
 		err:      nil,
-		out:      &bytes.Buffer{},
+		out:      buf,
+		rawOut:   buf,
 		bo:       bo,
 		implicit: implicit,
 	}
@@ -58,11 +80,15 @@ func NewBytesEncoder(bo binary.ByteOrder, implicit IsImplicitVR) *Encoder {
 // a transfersyntaxuid.
 func NewBytesEncoderWithTransferSyntax(transferSyntaxUID string) *Encoder {
 	endian, implicit, err := ParseTransferSyntaxUID(transferSyntaxUID)
-	if err == nil {
-		return NewBytesEncoder(endian, implicit)
+	if err != nil {
+		e := NewBytesEncoder(binary.LittleEndian, ExplicitVR)
+		e.SetErrorf("%v: Unknown transfer syntax uid", transferSyntaxUID)
+		return e
+	}
+	e := NewBytesEncoder(endian, implicit)
+	if IsDeflatedTransferSyntax(transferSyntaxUID) {
+		e.wrapDeflate()
 	}
-	e := NewBytesEncoder(binary.LittleEndian, ExplicitVR)
-	e.SetErrorf("%v: Unknown transfer syntax uid", transferSyntaxUID)
 	return e
 }
 
@@ -70,24 +96,57 @@ func NewBytesEncoderWithTransferSyntax(transferSyntaxUID string) *Encoder {
 // transfersyntaxuid.
 func NewEncoderWithTransferSyntax(out io.Writer, transferSyntaxUID string) *Encoder {
 	endian, implicit, err := ParseTransferSyntaxUID(transferSyntaxUID)
-	if err == nil {
-		return NewEncoder(out, endian, implicit)
+	if err != nil {
+		e := NewEncoder(out, binary.LittleEndian, ExplicitVR)
+		e.SetErrorf("%v: Unknown transfer syntax uid", transferSyntaxUID)
+		return e
+	}
+	e := NewEncoder(out, endian, implicit)
+	if IsDeflatedTransferSyntax(transferSyntaxUID) {
+		e.wrapDeflate()
 	}
-	e := NewEncoder(out, binary.LittleEndian, ExplicitVR)
-	e.SetErrorf("%v: Unknown transfer syntax uid", transferSyntaxUID)
 	return e
 }
 
+// wrapDeflate wraps e.out in a flate.Writer, recording e.flateOut so that
+// Bytes/Close/PopTransferSyntax know to flush and restore it later. Any
+// error from flate.NewWriter (only possible with an invalid compression
+// level, which this package never passes) is recorded via SetError.
+func (e *Encoder) wrapDeflate() {
+	w, err := flate.NewWriter(e.out, flate.DefaultCompression)
+	if err != nil {
+		e.SetError(err)
+		return
+	}
+	e.flateOut = w
+	e.out = w
+}
+
 // NewEncoder creates a new encoder that writes to "out".
 func NewEncoder(out io.Writer, bo binary.ByteOrder, implicit IsImplicitVR) *Encoder {
 	return &Encoder{
 		err:      nil,
 		out:      out,
+		rawOut:   out,
 		bo:       bo,
 		implicit: implicit,
 	}
 }
 
+// Reset reinitializes the Encoder to write to "out", as if it had just been
+// returned by NewEncoder. This lets a caller that processes many small DICOM
+// instances reuse a single Encoder (and its scratch buffers) instead of
+// allocating a fresh one per file.
+func (e *Encoder) Reset(out io.Writer, bo binary.ByteOrder, implicit IsImplicitVR) {
+	e.err = nil
+	e.out = out
+	e.rawOut = out
+	e.flateOut = nil
+	e.bo = bo
+	e.implicit = implicit
+	e.oldTransferSyntaxes = e.oldTransferSyntaxes[:0]
+}
+
 // TransferSyntax returns the current transfer syntax.
 func (e *Encoder) TransferSyntax() (binary.ByteOrder, IsImplicitVR) {
 	return e.bo, e.implicit
@@ -97,18 +156,46 @@ func (e *Encoder) TransferSyntax() (binary.ByteOrder, IsImplicitVR) {
 // format. PopTrasnferSyntax() will restore the old format.
 func (e *Encoder) PushTransferSyntax(bo binary.ByteOrder, implicit IsImplicitVR) {
 	e.oldTransferSyntaxes = append(e.oldTransferSyntaxes,
-		transferSyntaxStackEntry{e.bo, e.implicit})
+		transferSyntaxStackEntry{bo: e.bo, implicit: e.implicit, out: e.out, flateOut: e.flateOut})
+	e.bo = bo
+	e.implicit = implicit
+}
+
+// PushTransferSyntaxByUID is similar to PushTransferSyntax, but it takes a
+// transfer syntax UID. If "uid" identifies a deflated transfer syntax, the
+// encoder's output is wrapped in a flate.Writer so that a dataset can switch
+// into Deflated Explicit VR Little Endian mid-stream; the wrapping is undone
+// by the matching PopTransferSyntax.
+func (e *Encoder) PushTransferSyntaxByUID(uid string) {
+	bo, implicit, err := ParseTransferSyntaxUID(uid)
+	if err != nil {
+		e.SetError(err)
+	}
+	e.oldTransferSyntaxes = append(e.oldTransferSyntaxes,
+		transferSyntaxStackEntry{bo: e.bo, implicit: e.implicit, out: e.out, flateOut: e.flateOut})
 	e.bo = bo
 	e.implicit = implicit
+	if IsDeflatedTransferSyntax(uid) {
+		e.wrapDeflate()
+	}
 }
 
 // PopTransferSyntax restores the encoding format active before the last call to
-// PushTransferSyntax().
+// PushTransferSyntax(). If the transfer syntax being popped had wrapped the
+// output in a flate.Writer, that writer is flushed and closed first.
 func (e *Encoder) PopTransferSyntax() {
-	ts := e.oldTransferSyntaxes[len(e.oldTransferSyntaxes)-1]
+	last := len(e.oldTransferSyntaxes) - 1
+	ts := e.oldTransferSyntaxes[last]
+	if e.flateOut != nil && e.flateOut != ts.flateOut {
+		if err := e.flateOut.Close(); err != nil {
+			e.SetError(err)
+		}
+	}
 	e.bo = ts.bo
 	e.implicit = ts.implicit
-	e.oldTransferSyntaxes = e.oldTransferSyntaxes[:len(e.oldTransferSyntaxes)-1]
+	e.out = ts.out
+	e.flateOut = ts.flateOut
+	e.oldTransferSyntaxes = e.oldTransferSyntaxes[:last]
 }
 
 // SetError sets the error to be reported by future Error() calls.  If called
@@ -137,50 +224,80 @@ func (e *Encoder) Error() error { return e.err }
 // REQUIRES: e.Error() == nil.
 func (e *Encoder) Bytes() []byte {
 	doassert(len(e.oldTransferSyntaxes) == 0)
+	if e.flateOut != nil {
+		if err := e.flateOut.Close(); err != nil {
+			e.SetError(err)
+		}
+		e.flateOut = nil
+		e.out = e.rawOut
+	}
 	if e.err != nil {
 		panic(e.err)
 	}
-	return e.out.(*bytes.Buffer).Bytes()
+	return e.rawOut.(*bytes.Buffer).Bytes()
+}
+
+// Close flushes any data buffered by a deflated transfer syntax to the
+// underlying writer. It is a no-op for non-deflated transfer syntaxes, but
+// should be called unconditionally once the caller is done writing, since an
+// Encoder created with NewEncoderWithTransferSyntax may or may not be
+// deflated.
+func (e *Encoder) Close() error {
+	if e.flateOut != nil {
+		if err := e.flateOut.Close(); err != nil {
+			e.SetError(err)
+		}
+		e.flateOut = nil
+		e.out = e.rawOut
+	}
+	return e.err
 }
 
 func (e *Encoder) WriteByte(v byte) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.scratch[0] = v
+	if _, err := e.out.Write(e.scratch[:1]); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteUInt16(v uint16) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.bo.PutUint16(e.scratch[:2], v)
+	if _, err := e.out.Write(e.scratch[:2]); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteUInt32(v uint32) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.bo.PutUint32(e.scratch[:4], v)
+	if _, err := e.out.Write(e.scratch[:4]); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteInt16(v int16) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.bo.PutUint16(e.scratch[:2], uint16(v))
+	if _, err := e.out.Write(e.scratch[:2]); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteInt32(v int32) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.bo.PutUint32(e.scratch[:4], uint32(v))
+	if _, err := e.out.Write(e.scratch[:4]); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteFloat32(v float32) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.bo.PutUint32(e.scratch[:4], math.Float32bits(v))
+	if _, err := e.out.Write(e.scratch[:4]); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteFloat64(v float64) {
-	if err := binary.Write(e.out, e.bo, &v); err != nil {
+	e.bo.PutUint64(e.scratch[:8], math.Float64bits(v))
+	if _, err := e.out.Write(e.scratch[:8]); err != nil {
 		e.SetError(err)
 	}
 }
@@ -192,11 +309,23 @@ func (e *Encoder) WriteString(v string) {
 	}
 }
 
+// zeroChunk is a shared, never-mutated buffer of zero bytes used by
+// WriteZeros to write long runs of zeros without allocating.
+var zeroChunk [4096]byte
+
 // WriteZeros encodes an array of zero bytes.
 func (e *Encoder) WriteZeros(len int) {
-	// TODO(saito) reuse the buffer!
-	zeros := make([]byte, len)
-	e.out.Write(zeros)
+	for len > 0 {
+		n := len
+		if n > cap(zeroChunk) {
+			n = cap(zeroChunk)
+		}
+		if _, err := e.out.Write(zeroChunk[:n]); err != nil {
+			e.SetError(err)
+			return
+		}
+		len -= n
+	}
 }
 
 // Copy the given data to the output.
@@ -225,16 +354,27 @@ const (
 
 // Decoder is a helper class for decoder low-level DICOM data types.
 type Decoder struct {
+	// in is the reader currently being read from. It wraps flateIn when the
+	// current transfer syntax is Deflated Explicit VR Little Endian.
 	in  *bufio.Reader
 	err error
 	bo  binary.ByteOrder
 	// "implicit" isn't used by Decoder internally. It's there for the user
 	// of Decoder to see the current transfer syntax.
 	implicit IsImplicitVR
+	// flateIn is non-nil iff the current transfer syntax is deflated; it is
+	// the raw-deflate stream that "in" is bufio-wrapping.
+	flateIn io.ReadCloser
 	// Max bytes to read from "in".
 	limit int64
 	// Cumulative # bytes read.
 	pos int64
+	// streaming is true for decoders created by NewStreamingDecoder, where
+	// "limit" is a placeholder rather than an accurate bound. It makes
+	// PopLimit and Finish treat an io.EOF surfacing from the underlying
+	// reader as a clean stream terminator instead of an error. EOF does not
+	// consult it: EOF reports true for any decoder whose reader is exhausted.
+	streaming bool
 
 	// For decoding raw strings in DICOM file into utf-8.
 	// If nil, assume ASCII. Cf P3.5 6.1.2.1
@@ -264,6 +404,45 @@ func NewDecoder(
 	}
 }
 
+// Reset reinitializes the Decoder to read from "in", as if it had just been
+// returned by NewDecoder. The underlying bufio.Reader is reused via
+// bufio.Reader.Reset, so a caller that processes many small DICOM instances
+// (e.g. a DICOMweb server or a batched anonymizer pipeline) can amortize the
+// allocation of the Decoder and its read buffer across files instead of
+// building a fresh one per file.
+func (d *Decoder) Reset(in io.Reader, bo binary.ByteOrder, implicit IsImplicitVR) {
+	d.err = nil
+	d.bo = bo
+	d.implicit = implicit
+	d.pos = 0
+	d.limit = math.MaxInt64
+	d.streaming = false
+	d.codingSystem = CodingSystem{}
+	d.flateIn = nil
+	d.oldTransferSyntaxes = d.oldTransferSyntaxes[:0]
+	d.stateStack = d.stateStack[:0]
+	if d.in == nil {
+		d.in = bufio.NewReader(in)
+	} else {
+		d.in.Reset(in)
+	}
+}
+
+// NewStreamingDecoder creates a decoder for a reader whose total length isn't
+// known up front, such as a PACS C-STORE or DICOMweb chunked transfer read
+// off the network. Unlike NewDecoder, it does not require the caller to
+// supply an accurate "limit": EOF, PopLimit, and Finish all treat the
+// underlying reader running out of data as an ordinary, clean end of stream
+// rather than an error, as long as it happens at an element boundary.
+func NewStreamingDecoder(
+	in io.Reader,
+	bo binary.ByteOrder,
+	implicit IsImplicitVR) *Decoder {
+	d := NewDecoder(in, bo, implicit)
+	d.streaming = true
+	return d
+}
+
 // NewBytesDecoder creates a decoder that reads from a sequence of bytes. See
 // NewDecoder() for explanation of other parameters.
 func NewBytesDecoder(data []byte, bo binary.ByteOrder, implicit IsImplicitVR) *Decoder {
@@ -274,11 +453,16 @@ func NewBytesDecoder(data []byte, bo binary.ByteOrder, implicit IsImplicitVR) *D
 // a transfer syntax UID instead of a <byteorder, IsImplicitVR> pair.
 func NewBytesDecoderWithTransferSyntax(data []byte, transferSyntaxUID string) *Decoder {
 	endian, implicit, err := ParseTransferSyntaxUID(transferSyntaxUID)
-	if err == nil {
-		return NewBytesDecoder(data, endian, implicit)
+	if err != nil {
+		d := NewBytesDecoder(data, binary.LittleEndian, ExplicitVR)
+		d.SetError(fmt.Errorf("%v: Unknown transfer syntax uid", transferSyntaxUID))
+		return d
+	}
+	d := NewBytesDecoder(data, endian, implicit)
+	if IsDeflatedTransferSyntax(transferSyntaxUID) {
+		d.flateIn = flate.NewReader(d.in)
+		d.in = bufio.NewReader(d.flateIn)
 	}
-	d := NewBytesDecoder(data, binary.LittleEndian, ExplicitVR)
-	d.SetError(fmt.Errorf("%v: Unknown transfer syntax uid", transferSyntaxUID))
 	return d
 }
 
@@ -307,19 +491,30 @@ func (d *Decoder) TransferSyntax() (bo binary.ByteOrder, implicit IsImplicitVR)
 // PushTransferSyntax temporarily changes the encoding
 // format. PopTrasnferSyntax() will restore the old format.
 func (d *Decoder) PushTransferSyntax(bo binary.ByteOrder, implicit IsImplicitVR) {
-	d.oldTransferSyntaxes = append(d.oldTransferSyntaxes, transferSyntaxStackEntry{d.bo, d.implicit})
+	d.oldTransferSyntaxes = append(d.oldTransferSyntaxes,
+		transferSyntaxStackEntry{bo: d.bo, implicit: d.implicit, in: d.in, flateIn: d.flateIn})
 	d.bo = bo
 	d.implicit = implicit
 }
 
 // PushTransferSyntaxByUID is similar to PushTransferSyntax, but it takes a
-// transfer syntax UID.
+// transfer syntax UID. If "uid" identifies a deflated transfer syntax, the
+// decoder's input is wrapped in a flate.Reader so that a dataset can switch
+// into Deflated Explicit VR Little Endian mid-stream; the wrapping is undone
+// by the matching PopTransferSyntax.
 func (d *Decoder) PushTransferSyntaxByUID(uid string) {
-	endian, implicit, err := ParseTransferSyntaxUID(uid)
+	bo, implicit, err := ParseTransferSyntaxUID(uid)
 	if err != nil {
 		d.SetError(err)
 	}
-	d.PushTransferSyntax(endian, implicit)
+	d.oldTransferSyntaxes = append(d.oldTransferSyntaxes,
+		transferSyntaxStackEntry{bo: d.bo, implicit: d.implicit, in: d.in, flateIn: d.flateIn})
+	d.bo = bo
+	d.implicit = implicit
+	if IsDeflatedTransferSyntax(uid) {
+		d.flateIn = flate.NewReader(d.in)
+		d.in = bufio.NewReader(d.flateIn)
+	}
 }
 
 // SetCodingSystem overrides the default (7bit ASCII) decoder used when
@@ -329,12 +524,21 @@ func (d *Decoder) SetCodingSystem(cs CodingSystem) {
 }
 
 // PopTransferSyntax restores the encoding format active before the last call to
-// PushTransferSyntax().
+// PushTransferSyntax(). If the transfer syntax being popped had wrapped the
+// input in a flate.Reader, that reader is closed first.
 func (d *Decoder) PopTransferSyntax() {
-	e := d.oldTransferSyntaxes[len(d.oldTransferSyntaxes)-1]
+	last := len(d.oldTransferSyntaxes) - 1
+	e := d.oldTransferSyntaxes[last]
+	if d.flateIn != nil && d.flateIn != e.flateIn {
+		if err := d.flateIn.Close(); err != nil {
+			d.SetError(err)
+		}
+	}
 	d.bo = e.bo
 	d.implicit = e.implicit
-	d.oldTransferSyntaxes = d.oldTransferSyntaxes[:len(d.oldTransferSyntaxes)-1]
+	d.in = e.in
+	d.flateIn = e.flateIn
+	d.oldTransferSyntaxes = d.oldTransferSyntaxes[:last]
 }
 
 // PushLimit temporarily overrides the end of the buffer and clears
@@ -360,6 +564,14 @@ func (d *Decoder) PopLimit() {
 		// heuristics to parse as much data as possible from corrupt files.
 		d.Skip(int(d.limit - d.pos))
 	}
+	if d.streaming && d.err == io.EOF {
+		// The underlying reader simply ran out of data with nothing read
+		// for the pending element (see ReadBytes/Skip and the binary.Read-based
+		// ReadUInt16 etc.: they report io.ErrUnexpectedEOF, not io.EOF, once
+		// any bytes were consumed),
+		// so this is a clean stream terminator, not a parse error.
+		d.err = nil
+	}
 	last := len(d.stateStack) - 1
 	d.limit = d.stateStack[last].limit
 	if d.stateStack[last].err != nil {
@@ -376,6 +588,13 @@ func (d *Decoder) Error() error { return d.err }
 // unconsumed.
 func (d *Decoder) Finish() error {
 	if d.err != nil {
+		if d.streaming && d.err == io.EOF {
+			// A literal io.EOF means the reader ran out before any bytes of
+			// the pending element were consumed (ReadBytes/Skip report
+			// io.ErrUnexpectedEOF otherwise), so it's a clean stream end,
+			// not a truncated element.
+			return nil
+		}
 		return d.err
 	}
 	if !d.EOF() {
@@ -519,25 +738,21 @@ func (d *Decoder) ReadString(length int) string {
 	return internalReadString(d, d.codingSystem.Ideographic, length)
 }
 
+// ReadBytes uses io.ReadFull rather than a bare loop over Read so that a
+// reader running out of data partway through is reported as
+// io.ErrUnexpectedEOF, not io.EOF: NewStreamingDecoder only forgives the
+// latter, and a streaming decoder must still treat a read that's truncated
+// after consuming some of its length as a truncation error, not a clean
+// stream end (see PopLimit and Finish).
 func (d *Decoder) ReadBytes(length int) []byte {
 	if d.len() < int64(length) {
 		d.SetError(fmt.Errorf("ReadBytes: requested %d, available %d", length, d.len()))
 		return nil
 	}
 	v := make([]byte, length)
-	remaining := v
-	for len(remaining) > 0 {
-		n, err := d.Read(remaining)
-		if err != nil {
-			d.SetError(err)
-			break
-		}
-		if n < 0 || n > len(remaining) {
-			panic(fmt.Sprintf("Remaining: %d %d", n, len(remaining)))
-		}
-		remaining = remaining[n:]
+	if _, err := io.ReadFull(d, v); err != nil {
+		d.SetError(err)
 	}
-	doassert(d.err != nil || len(remaining) == 0)
 	return v
 }
 
@@ -562,6 +777,13 @@ func (d *Decoder) Skip(length int) {
 		tmpBuf := junk[:tmpLength]
 		n, err := d.Read(tmpBuf)
 		if err != nil {
+			if err == io.EOF && remaining < length {
+				// Some of this Skip's length was already consumed before
+				// the reader ran out: a truncation, not the clean,
+				// zero-bytes-consumed io.EOF that a streaming decoder
+				// forgives.
+				err = io.ErrUnexpectedEOF
+			}
 			d.SetError(err)
 			break
 		}
@@ -0,0 +1,31 @@
+package dicomio
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func BenchmarkWriteUInt16(b *testing.B) {
+	e := NewEncoder(ioutil.Discard, binary.LittleEndian, ExplicitVR)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.WriteUInt16(uint16(i))
+	}
+}
+
+func BenchmarkWriteUInt32(b *testing.B) {
+	e := NewEncoder(ioutil.Discard, binary.LittleEndian, ExplicitVR)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.WriteUInt32(uint32(i))
+	}
+}
+
+func BenchmarkWriteZeros(b *testing.B) {
+	e := NewEncoder(ioutil.Discard, binary.LittleEndian, ExplicitVR)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.WriteZeros(65536)
+	}
+}
@@ -0,0 +1,75 @@
+package dicomio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestDeflatedTransferSyntaxRoundTrip writes a small synthetic dataset using
+// the Deflated Explicit VR Little Endian transfer syntax and checks that it
+// reads back unchanged.
+func TestDeflatedTransferSyntaxRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	e := NewEncoderWithTransferSyntax(&out, DeflatedExplicitVRLittleEndianUID)
+	e.WriteUInt16(0x0008)
+	e.WriteUInt16(0x0010)
+	e.WriteString("ORIGINAL")
+	e.WriteUInt32(12345)
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := e.Error(); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	dd := NewBytesDecoderWithTransferSyntax(out.Bytes(), DeflatedExplicitVRLittleEndianUID)
+	if got, want := dd.ReadUInt16(), uint16(0x0008); got != want {
+		t.Errorf("group: got %v, want %v", got, want)
+	}
+	if got, want := dd.ReadUInt16(), uint16(0x0010); got != want {
+		t.Errorf("element: got %v, want %v", got, want)
+	}
+	if got, want := dd.ReadString(len("ORIGINAL")), "ORIGINAL"; got != want {
+		t.Errorf("string: got %q, want %q", got, want)
+	}
+	if got, want := dd.ReadUInt32(), uint32(12345); got != want {
+		t.Errorf("uint32: got %v, want %v", got, want)
+	}
+	if err := dd.Finish(); err != nil {
+		t.Errorf("Finish: %v", err)
+	}
+}
+
+// TestPushPopDeflateTransferSyntaxMidStream checks that a dataset can switch
+// into Deflated Explicit VR Little Endian partway through, via
+// Push/PopTransferSyntaxByUID, and back out again: the File Meta
+// Information of a real DICOM stream is always raw, with only the dataset
+// that follows it optionally deflated.
+func TestPushPopDeflateTransferSyntaxMidStream(t *testing.T) {
+	e := NewBytesEncoder(binary.LittleEndian, ExplicitVR)
+	e.WriteString("RAW_PREFIX")
+	e.PushTransferSyntaxByUID(DeflatedExplicitVRLittleEndianUID)
+	e.WriteString("DEFLATED")
+	e.PopTransferSyntax()
+	e.WriteString("RAW_SUFFIX")
+	if err := e.Error(); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	d := NewBytesDecoder(e.Bytes(), binary.LittleEndian, ExplicitVR)
+	if got, want := d.ReadString(len("RAW_PREFIX")), "RAW_PREFIX"; got != want {
+		t.Errorf("raw prefix: got %q, want %q", got, want)
+	}
+	d.PushTransferSyntaxByUID(DeflatedExplicitVRLittleEndianUID)
+	if got, want := d.ReadString(len("DEFLATED")), "DEFLATED"; got != want {
+		t.Errorf("deflated: got %q, want %q", got, want)
+	}
+	d.PopTransferSyntax()
+	if got, want := d.ReadString(len("RAW_SUFFIX")), "RAW_SUFFIX"; got != want {
+		t.Errorf("raw suffix: got %q, want %q", got, want)
+	}
+	if err := d.Finish(); err != nil {
+		t.Errorf("Finish: %v", err)
+	}
+}
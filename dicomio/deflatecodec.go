@@ -0,0 +1,41 @@
+package dicomio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// DeflatePixelCodec is a PixelCodec backed by raw RFC 1951 deflate, for
+// private or experimental transfer syntaxes that store encapsulated pixel
+// data frames as plain deflate streams (as opposed to
+// DeflatedExplicitVRLittleEndianUID, which deflates the entire dataset
+// rather than individual frames). It isn't registered for any standard UID
+// by default; callers should RegisterPixelCodec it under whichever
+// transfer syntax UID their source uses.
+var DeflatePixelCodec PixelCodec = deflateCodec{}
+
+type deflateCodec struct{}
+
+func (deflateCodec) DecodeFrame(r io.Reader) ([]byte, error) {
+	fr := flate.NewReader(r)
+	defer fr.Close()
+	return ioutil.ReadAll(fr)
+}
+
+func (deflateCodec) EncodeFrame(raw []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := flate.NewWriter(&out, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
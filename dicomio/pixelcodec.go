@@ -0,0 +1,42 @@
+package dicomio
+
+import (
+	"io"
+	"sync"
+)
+
+// PixelCodec encodes and decodes a single frame of encapsulated pixel data
+// for one compressed transfer syntax. DecodeFrame receives exactly one
+// encapsulated pixel-data fragment (a single frame) and returns the raw,
+// uncompressed pixel bytes; EncodeFrame is its inverse.
+type PixelCodec interface {
+	DecodeFrame(r io.Reader) ([]byte, error)
+	EncodeFrame(raw []byte) ([]byte, error)
+}
+
+var (
+	pixelCodecsMu sync.RWMutex
+	pixelCodecs   = map[string]PixelCodec{}
+)
+
+// RegisterPixelCodec registers "codec" as the PixelCodec to use for
+// encapsulated PixelData elements found in a dataset whose transfer syntax
+// is "transferSyntaxUID". It is typically called from an init() function.
+// Registering under a UID that's already registered replaces the existing
+// codec.
+func RegisterPixelCodec(transferSyntaxUID string, codec PixelCodec) {
+	pixelCodecsMu.Lock()
+	defer pixelCodecsMu.Unlock()
+	pixelCodecs[transferSyntaxUID] = codec
+}
+
+// LookupPixelCodec returns the PixelCodec registered for
+// "transferSyntaxUID", if any. Callers decoding or encoding encapsulated
+// PixelData should consult this after computing the transfer syntax with
+// ParseTransferSyntaxUID.
+func LookupPixelCodec(transferSyntaxUID string) (codec PixelCodec, ok bool) {
+	pixelCodecsMu.RLock()
+	defer pixelCodecsMu.RUnlock()
+	codec, ok = pixelCodecs[transferSyntaxUID]
+	return codec, ok
+}
@@ -0,0 +1,72 @@
+package dicomio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRLECodecRoundTrip(t *testing.T) {
+	raw := []byte{1, 1, 1, 1, 1, 2, 3, 4, 5, 5, 5, 0, 0, 0, 0, 0, 0, 9}
+	codec, ok := LookupPixelCodec(RLELosslessUID)
+	if !ok {
+		t.Fatalf("RLE codec not registered under %q", RLELosslessUID)
+	}
+	encoded, err := codec.EncodeFrame(raw)
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	decoded, err := codec.DecodeFrame(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	if !bytes.Equal(decoded, raw) {
+		t.Errorf("round trip mismatch: got %v, want %v", decoded, raw)
+	}
+}
+
+func TestDeflatePixelCodecRoundTrip(t *testing.T) {
+	raw := []byte("some synthetic pixel frame data, repeated repeated repeated")
+	encoded, err := DeflatePixelCodec.EncodeFrame(raw)
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	decoded, err := DeflatePixelCodec.DecodeFrame(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	if !bytes.Equal(decoded, raw) {
+		t.Errorf("round trip mismatch: got %q, want %q", decoded, raw)
+	}
+}
+
+func TestLookupPixelCodecUnregistered(t *testing.T) {
+	if _, ok := LookupPixelCodec("1.2.3.4.5.not.a.real.codec"); ok {
+		t.Errorf("LookupPixelCodec: got ok=true for an unregistered UID")
+	}
+}
+
+// TestRLELosslessTransferSyntaxRecognized checks that RLELosslessUID is
+// reachable through the package's normal construction entry points, not
+// just through LookupPixelCodec: a dataset declaring it should decode like
+// any other Explicit VR Little Endian dataset for every element outside the
+// codec-compressed PixelData itself.
+func TestRLELosslessTransferSyntaxRecognized(t *testing.T) {
+	var out bytes.Buffer
+	e := NewEncoderWithTransferSyntax(&out, RLELosslessUID)
+	e.WriteUInt16(0x0008)
+	e.WriteUInt16(0x0010)
+	if err := e.Error(); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	d := NewBytesDecoderWithTransferSyntax(out.Bytes(), RLELosslessUID)
+	if got, want := d.ReadUInt16(), uint16(0x0008); got != want {
+		t.Errorf("group: got %v, want %v", got, want)
+	}
+	if got, want := d.ReadUInt16(), uint16(0x0010); got != want {
+		t.Errorf("element: got %v, want %v", got, want)
+	}
+	if err := d.Finish(); err != nil {
+		t.Errorf("Finish: %v", err)
+	}
+}
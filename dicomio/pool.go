@@ -0,0 +1,27 @@
+package dicomio
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+var decoderPool = sync.Pool{
+	New: func() interface{} { return &Decoder{} },
+}
+
+// GetDecoder returns a Decoder reading from "in", reusing one from a package-
+// level pool when possible. Callers that process many small DICOM instances
+// should call PutDecoder once they're done with the Decoder returned here,
+// rather than letting it be garbage collected.
+func GetDecoder(in io.Reader, bo binary.ByteOrder, implicit IsImplicitVR) *Decoder {
+	d := decoderPool.Get().(*Decoder)
+	d.Reset(in, bo, implicit)
+	return d
+}
+
+// PutDecoder returns "d" to the package-level pool used by GetDecoder. "d"
+// must not be used again after calling PutDecoder.
+func PutDecoder(d *Decoder) {
+	decoderPool.Put(d)
+}
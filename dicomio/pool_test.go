@@ -0,0 +1,42 @@
+package dicomio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func smallInstance() []byte {
+	e := NewBytesEncoder(binary.LittleEndian, ExplicitVR)
+	e.WriteUInt16(0x0008)
+	e.WriteUInt16(0x0010)
+	e.WriteString("SOMEVALUE")
+	return e.Bytes()
+}
+
+// BenchmarkDecodeFreshPerFile decodes many small instances, allocating a new
+// Decoder (and its bufio.Reader) for each one.
+func BenchmarkDecodeFreshPerFile(b *testing.B) {
+	data := smallInstance()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := NewBytesDecoder(data, binary.LittleEndian, ExplicitVR)
+		d.ReadUInt16()
+		d.ReadUInt16()
+		d.ReadString(len("SOMEVALUE"))
+	}
+}
+
+// BenchmarkDecodePooled decodes many small instances reusing a single
+// Decoder via GetDecoder/PutDecoder.
+func BenchmarkDecodePooled(b *testing.B) {
+	data := smallInstance()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := GetDecoder(bytes.NewReader(data), binary.LittleEndian, ExplicitVR)
+		d.ReadUInt16()
+		d.ReadUInt16()
+		d.ReadString(len("SOMEVALUE"))
+		PutDecoder(d)
+	}
+}
@@ -0,0 +1,152 @@
+package dicomio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// RLELosslessUID is the transfer syntax UID for RLE Lossless (PS3.5 Annex
+// G), the DICOM-defined byte-oriented run-length encoding for pixel data.
+const RLELosslessUID = "1.2.840.10008.1.2.5"
+
+func init() {
+	RegisterPixelCodec(RLELosslessUID, rleCodec{})
+}
+
+// rleCodec implements the RLE Lossless codec described in PS3.5 Annex G: a
+// frame is split into up to 15 segments (e.g. one per bit-plane or color
+// plane), each individually run-length encoded, preceded by a 64-byte header
+// giving the segment count and each segment's byte offset.
+type rleCodec struct{}
+
+const rleHeaderSize = 64
+const rleMaxSegments = 15
+
+// DecodeFrame decompresses a single RLE Lossless encapsulated frame, as read
+// from one PixelData item. The segments are decoded and concatenated in
+// order.
+func (rleCodec) DecodeFrame(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < rleHeaderSize {
+		return nil, fmt.Errorf("rle: frame too short for header: %d bytes", len(data))
+	}
+	// The segment header is a fixed little-endian wire format (PS3.5 Annex
+	// G.2), not NativeByteOrder: it doesn't vary with the host running this
+	// code.
+	numSegments := int(binary.LittleEndian.Uint32(data[0:4]))
+	if numSegments < 0 || numSegments > rleMaxSegments {
+		return nil, fmt.Errorf("rle: invalid segment count %d", numSegments)
+	}
+	offsets := make([]int, numSegments)
+	for i := 0; i < numSegments; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(data[4+4*i : 8+4*i]))
+	}
+	var out bytes.Buffer
+	for i := 0; i < numSegments; i++ {
+		start := offsets[i]
+		end := len(data)
+		if i+1 < numSegments {
+			end = offsets[i+1]
+		}
+		if start < 0 || end > len(data) || start > end {
+			return nil, fmt.Errorf("rle: invalid segment %d bounds [%d, %d)", i, start, end)
+		}
+		if err := rleDecodeSegment(data[start:end], &out); err != nil {
+			return nil, fmt.Errorf("rle: segment %d: %v", i, err)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// rleDecodeSegment decodes one PS3.5 Annex G.3 run-length-encoded segment
+// into "out".
+func rleDecodeSegment(seg []byte, out *bytes.Buffer) error {
+	for i := 0; i < len(seg); {
+		n := int(int8(seg[i]))
+		i++
+		switch {
+		case n >= 0:
+			// Copy the next n+1 bytes literally.
+			end := i + n + 1
+			if end > len(seg) {
+				return fmt.Errorf("literal run overruns segment")
+			}
+			out.Write(seg[i:end])
+			i = end
+		case n != -128:
+			// Replicate the next byte -n+1 times.
+			if i >= len(seg) {
+				return fmt.Errorf("replicate run missing byte")
+			}
+			count := -n + 1
+			b := seg[i]
+			i++
+			for j := 0; j < count; j++ {
+				out.WriteByte(b)
+			}
+		default:
+			// n == -128 is a no-op, used only for padding.
+		}
+	}
+	return nil
+}
+
+// EncodeFrame compresses "raw" into a single-segment RLE Lossless frame.
+// Multi-segment (planar) encoding is left to callers that need it; most
+// single-sample, single-plane frames only need one segment.
+func (rleCodec) EncodeFrame(raw []byte) ([]byte, error) {
+	var header [rleHeaderSize]byte
+	// See the matching note in DecodeFrame: the header is always
+	// little-endian on the wire.
+	binary.LittleEndian.PutUint32(header[0:4], 1)
+	binary.LittleEndian.PutUint32(header[4:8], rleHeaderSize)
+
+	var out bytes.Buffer
+	out.Write(header[:])
+	rleEncodeSegment(raw, &out)
+	return out.Bytes(), nil
+}
+
+// rleEncodeSegment appends a PS3.5 Annex G.3 run-length encoding of "seg" to
+// "out". It favors simplicity over optimal compression: it only emits
+// replicate runs for runs of at least 3 identical bytes.
+func rleEncodeSegment(seg []byte, out *bytes.Buffer) {
+	const maxLiteral = 128
+	const maxReplicate = 128
+
+	i := 0
+	for i < len(seg) {
+		runLen := 1
+		for i+runLen < len(seg) && seg[i+runLen] == seg[i] && runLen < maxReplicate {
+			runLen++
+		}
+		if runLen >= 3 {
+			out.WriteByte(byte(int8(-(runLen - 1))))
+			out.WriteByte(seg[i])
+			i += runLen
+			continue
+		}
+		// Accumulate a literal run up to the next qualifying replicate run.
+		start := i
+		i++
+		for i < len(seg) && i-start < maxLiteral {
+			next := 1
+			for i+next < len(seg) && seg[i+next] == seg[i] && next < 3 {
+				next++
+			}
+			if next >= 3 {
+				break
+			}
+			i++
+		}
+		literal := seg[start:i]
+		out.WriteByte(byte(len(literal) - 1))
+		out.Write(literal)
+	}
+}
@@ -0,0 +1,62 @@
+package dicomio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestStreamingDecoderCleanEOF verifies that a NewStreamingDecoder treats
+// running out of input at an element boundary as a clean end of stream
+// rather than an error, the way data arriving off a network connection
+// would.
+func TestStreamingDecoderCleanEOF(t *testing.T) {
+	e := NewBytesEncoder(binary.LittleEndian, ExplicitVR)
+	e.WriteUInt16(0x0008)
+	e.WriteUInt16(0x0010)
+	e.WriteUInt32(42)
+
+	d := NewStreamingDecoder(bytes.NewReader(e.Bytes()), binary.LittleEndian, ExplicitVR)
+	if got, want := d.ReadUInt16(), uint16(0x0008); got != want {
+		t.Errorf("group: got %v, want %v", got, want)
+	}
+	if got, want := d.ReadUInt16(), uint16(0x0010); got != want {
+		t.Errorf("element: got %v, want %v", got, want)
+	}
+	if got, want := d.ReadUInt32(), uint32(42); got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+	if err := d.Finish(); err != nil {
+		t.Errorf("Finish: got %v, want nil", err)
+	}
+}
+
+// TestStreamingDecoderMidElementTruncationErrors verifies that a
+// NewStreamingDecoder does NOT forgive an io.EOF that cuts off an element
+// partway through its declared length: only a reader that runs dry before
+// any bytes of the pending element are consumed is a clean stream end: one
+// that runs dry after delivering some of an element's declared length is a
+// truncated/corrupted stream and must still surface as an error.
+func TestStreamingDecoderMidElementTruncationErrors(t *testing.T) {
+	// The frame declares 100 bytes of value, but the underlying reader
+	// only has 10 to give.
+	d := NewStreamingDecoder(bytes.NewReader(make([]byte, 10)), binary.LittleEndian, ExplicitVR)
+	d.PushLimit(100)
+	d.ReadBytes(100)
+	d.PopLimit()
+	if err := d.Finish(); err == nil {
+		t.Errorf("Finish: got nil, want an error for a truncated element")
+	}
+}
+
+// TestDecoderBoundedStillErrorsOnTruncation checks that a plain NewDecoder
+// still reports an error when more data is requested than is available,
+// preserving the existing bounded-decoder behavior.
+func TestDecoderBoundedStillErrorsOnTruncation(t *testing.T) {
+	d := NewBytesDecoder([]byte{0x01, 0x02}, binary.LittleEndian, ExplicitVR)
+	d.PushLimit(2)
+	d.ReadUInt32() // requests more bytes than are available within the limit
+	if err := d.Finish(); err == nil {
+		t.Errorf("Finish: got nil, want an error")
+	}
+}
@@ -0,0 +1,59 @@
+package dicomio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DICOM transfer syntax UIDs recognized by dicomio. See P3.5 A.4 for the
+// full, canonical registry; this package only needs to know enough about
+// each one to pick the right byte order, VR-ness, and stream framing.
+const (
+	ImplicitVRLittleEndianUID         = "1.2.840.10008.1.2"
+	ExplicitVRLittleEndianUID         = "1.2.840.10008.1.2.1"
+	DeflatedExplicitVRLittleEndianUID = "1.2.840.10008.1.2.1.99"
+	ExplicitVRBigEndianUID            = "1.2.840.10008.1.2.2"
+)
+
+// ParseTransferSyntaxUID converts a transfer syntax UID, as found in a DICOM
+// file's meta information or a network association, into the byte order and
+// implicit/explicit VR-ness that the rest of dicomio needs to decode the
+// data that follows.
+//
+// Note that for DeflatedExplicitVRLittleEndianUID, the data following the
+// File Meta Information is additionally raw-deflate compressed; callers that
+// care should check IsDeflatedTransferSyntax separately and wrap the
+// underlying stream accordingly (NewEncoderWithTransferSyntax and
+// NewBytesDecoderWithTransferSyntax do this already).
+//
+// Encapsulated transfer syntaxes (RLE, JPEG, JPEG 2000, ...) carry PixelData
+// as codec-compressed frames handled by a registered PixelCodec (see
+// RegisterPixelCodec) instead of by dicomio itself, but every other element
+// in the dataset is still framed as plain Explicit VR Little Endian. Rather
+// than hardcode a case per codec here, any UID not otherwise recognized is
+// looked up in the PixelCodec registry and, if found, treated as Explicit
+// VR Little Endian; this lets a downstream user add a new encapsulated
+// transfer syntax purely by calling RegisterPixelCodec, without forking
+// dicomio to teach this function about it.
+func ParseTransferSyntaxUID(uid string) (bo binary.ByteOrder, implicit IsImplicitVR, err error) {
+	switch uid {
+	case ImplicitVRLittleEndianUID:
+		return binary.LittleEndian, ImplicitVR, nil
+	case ExplicitVRLittleEndianUID, DeflatedExplicitVRLittleEndianUID:
+		return binary.LittleEndian, ExplicitVR, nil
+	case ExplicitVRBigEndianUID:
+		return binary.BigEndian, ExplicitVR, nil
+	default:
+		if _, ok := LookupPixelCodec(uid); ok {
+			return binary.LittleEndian, ExplicitVR, nil
+		}
+		return nil, UnknownVR, fmt.Errorf("%s: unknown transfer syntax uid", uid)
+	}
+}
+
+// IsDeflatedTransferSyntax reports whether "uid" identifies a transfer
+// syntax whose data, after the File Meta Information, is RFC 1951 raw
+// deflate compressed.
+func IsDeflatedTransferSyntax(uid string) bool {
+	return uid == DeflatedExplicitVRLittleEndianUID
+}